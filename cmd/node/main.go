@@ -9,12 +9,19 @@ import (
 
 	"distributed-key-value-storage/internal/consistenthash"
 	"distributed-key-value-storage/internal/gossip"
+	"distributed-key-value-storage/internal/hints"
 	"distributed-key-value-storage/internal/node"
+	"distributed-key-value-storage/internal/repair"
 	"distributed-key-value-storage/internal/store"
 )
 
 const (
 	ssTablesDir string = "/data"
+	hintsDir    string = "/data/hints"
+
+	hintReplayInterval = 10 * time.Second
+	swimProbeInterval  = 1 * time.Second
+	compactionInterval = 15 * time.Second
 )
 
 func main() {
@@ -31,12 +38,18 @@ func main() {
 		log.Fatal(err)
 	}
 
+	hintQueue, err := hints.NewQueue(hintsDir, hints.DefaultTTL, hints.DefaultMaxBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	n := node.NewNode(
 		id,
 		addr,
 		store.NewStore(ssTablesDir),
 		consistenthash.NewRing(100),
 		gossip.NewState(id, addr),
+		hintQueue,
 	)
 
 	// add ourselves and peers to the ring
@@ -49,11 +62,29 @@ func main() {
 	// start gossip
 	go gossip.Start(id, n.State, peers, 2*time.Second)
 
+	// start SWIM failure detection
+	gossip.StartFailureDetector(id, n.State, n.Ring, swimProbeInterval)
+
+	// start Merkle-tree anti-entropy repair
+	repair.NewSyncer(id, n.Store, n.State, repair.DefaultInterval).Start()
+
+	// start background SSTable compaction
+	n.Store.StartCompactor(compactionInterval)
+
+	// start hinted-handoff replay
+	n.StartHintReplay(hintReplayInterval)
+
 	// HTTP handlers
 	mux := http.NewServeMux()
 	mux.HandleFunc("/kv/", n.HandleKV)
 	mux.HandleFunc("/internal/kv", n.HandleInternalKV)
+	mux.HandleFunc("/internal/kv/digest", n.HandleInternalDigest)
+	mux.HandleFunc("/internal/merkle", n.HandleMerkle)
+	mux.HandleFunc("/internal/merkle/entries", n.HandleMerkleEntries)
+	mux.HandleFunc("/internal/hints", n.HandleHints)
 	mux.HandleFunc("/gossip", n.HandleGossip)
+	mux.HandleFunc("/gossip/ping", gossip.HandlePing)
+	mux.HandleFunc("/gossip/ping-req", gossip.HandlePingReq)
 
 	log.Printf("[%s] starting on %s", id, addr)
 	log.Fatal(http.ListenAndServe(addr, mux))
@@ -7,14 +7,17 @@ package consistenthash
 import (
 	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/cespare/xxhash/v2"
 )
 
 type Ring struct {
+	mu       sync.RWMutex
 	replicas int
 	hashMap  map[uint64]string // hash -> node ID
 	keys     []uint64
+	nodes    map[string]struct{} // distinct physical nodes currently in the ring
 }
 
 // NewRing constructs a ring with the given number of virtual node replicas.
@@ -22,27 +25,66 @@ func NewRing(replicas int) *Ring {
 	return &Ring{
 		replicas: replicas,
 		hashMap:  make(map[uint64]string),
+		nodes:    make(map[string]struct{}),
 	}
 }
 
 // Add inserts a node (physical) into the ring as 'replicas' virtual nodes.
 func (r *Ring) Add(nodeID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodes[nodeID]; ok {
+		return
+	}
+
 	for i := 0; i < r.replicas; i++ {
 		h := xxhash.Sum64String(strconv.Itoa(i) + nodeID)
 		r.keys = append(r.keys, h)
 		r.hashMap[h] = nodeID
 	}
 	sort.Slice(r.keys, func(i, j int) bool { return r.keys[i] < r.keys[j] })
+	r.nodes[nodeID] = struct{}{}
+}
+
+// Remove deletes every virtual node owned by nodeID, e.g. once the gossip
+// failure detector has declared it definitively Dead. Keys already handed
+// out by a prior Get are unaffected; future lookups simply skip nodeID.
+func (r *Ring) Remove(nodeID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.keys[:0]
+	for _, h := range r.keys {
+		if r.hashMap[h] == nodeID {
+			delete(r.hashMap, h)
+			continue
+		}
+		kept = append(kept, h)
+	}
+	r.keys = kept
+	delete(r.nodes, nodeID)
 }
 
 // Get returns up to 'num' distinct node IDs responsible for 'key'.
 // It uses consistent hashing to find the correct virtual nodes.
 func (r *Ring) Get(key string, num int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	// If the ring is empty or the requested number of nodes is incorrect - return Nil
 	if len(r.keys) == 0 || num <= 0 {
 		return nil
 	}
 
+	// Can't hand back more distinct physical nodes than are actually in
+	// the ring: once Remove has dropped the count below num (e.g. SWIM
+	// marking a node Dead in a 3-node cluster), the loop below would
+	// otherwise spin forever chasing a count it can never reach.
+	if num > len(r.nodes) {
+		num = len(r.nodes)
+	}
+
 	// calculate the hash from the key (xxhash - very fast and high-quality hash)
 	h := xxhash.Sum64String(key)
 
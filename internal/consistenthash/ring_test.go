@@ -0,0 +1,69 @@
+package consistenthash
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRingGetReturnsDistinctNodes(t *testing.T) {
+	r := NewRing(10)
+	r.Add("a")
+	r.Add("b")
+	r.Add("c")
+
+	got := r.Get("some-key", 3)
+	if len(got) != 3 {
+		t.Fatalf("got %d nodes, want 3", len(got))
+	}
+
+	seen := make(map[string]struct{})
+	for _, id := range got {
+		seen[id] = struct{}{}
+	}
+	if len(seen) != 3 {
+		t.Fatalf("got %v, want 3 distinct node IDs", got)
+	}
+}
+
+// TestRingGetClampsToAvailableNodes guards against Get spinning forever:
+// once Remove has dropped the ring below the requested replica count, the
+// visited set can never reach num and the scan loop would never return.
+func TestRingGetClampsToAvailableNodes(t *testing.T) {
+	r := NewRing(10)
+	r.Add("a")
+	r.Add("b")
+	r.Remove("a")
+
+	done := make(chan []string, 1)
+	go func() { done <- r.Get("some-key", 3) }()
+
+	select {
+	case got := <-done:
+		if len(got) != 1 || got[0] != "b" {
+			t.Fatalf("got %v, want exactly [\"b\"]", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get did not return: likely spinning past the number of remaining nodes")
+	}
+}
+
+func TestRingRemoveDropsAllVirtualNodes(t *testing.T) {
+	r := NewRing(10)
+	r.Add("a")
+	r.Add("b")
+	r.Remove("a")
+
+	for _, id := range r.hashMap {
+		if id == "a" {
+			t.Fatalf("Remove left a virtual node owned by %q in the ring", id)
+		}
+	}
+	if _, ok := r.nodes["a"]; ok {
+		t.Fatal("Remove did not drop \"a\" from the distinct node set")
+	}
+
+	got := r.Get("some-key", 1)
+	if len(got) != 1 || got[0] != "b" {
+		t.Fatalf("got %v, want [\"b\"] to be the only remaining node", got)
+	}
+}
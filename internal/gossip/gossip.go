@@ -13,32 +13,113 @@ import (
 	"time"
 )
 
+// NodeStatus is a node's SWIM failure-detector state, as known locally.
+type NodeStatus int
+
+const (
+	Alive NodeStatus = iota
+	Suspect
+	Dead
+)
+
 type State struct {
-	Nodes map[string]string `json:"nodes"` // nodeID -> addr
-	TS    int64             `json:"ts"`    // UnixNano
-	mu    sync.RWMutex
+	Nodes       map[string]string     `json:"nodes"`       // nodeID -> addr
+	Status      map[string]NodeStatus `json:"status"`      // nodeID -> SWIM status
+	Incarnation map[string]uint64     `json:"incarnation"` // nodeID -> incarnation number
+	TS          int64                 `json:"ts"`          // UnixNano
+
+	mu           sync.RWMutex
+	suspectSince map[string]time.Time // nodeID -> when we first suspected it
 }
 
 func NewState(id, addr string) *State {
 	return &State{
-		Nodes: map[string]string{id: addr},
-		TS:    time.Now().UnixNano(),
+		Nodes:        map[string]string{id: addr},
+		Status:       map[string]NodeStatus{id: Alive},
+		Incarnation:  map[string]uint64{id: 0},
+		TS:           time.Now().UnixNano(),
+		suspectSince: make(map[string]time.Time),
 	}
 }
 
-// Merge integrates `other` state if newer.
-func (s *State) Merge(other *State) {
+// Merge integrates `other` state. The membership map is taken wholesale
+// from whichever side has the newer TS, same as before SWIM; status and
+// incarnation are merged per node, so a higher incarnation (a node
+// refuting a Suspect rumor about itself) always wins regardless of which
+// side has the newer TS, and a same-incarnation Suspect/Dead report beats
+// a stale Alive. Returns the IDs of any node that just transitioned from
+// Suspect/Dead back to Alive, so the caller can re-add it to the hash
+// ring - sweepSuspects's ring.Remove is otherwise a one-way trip.
+func (s *State) Merge(other *State) []string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if other.TS <= s.TS {
-		return
+	if other.TS > s.TS {
+		for k, v := range other.Nodes {
+			s.Nodes[k] = v
+		}
+		s.TS = other.TS
+	}
+
+	var revived []string
+	for id, inc := range other.Incarnation {
+		cur, known := s.Incarnation[id]
+		if !known || inc > cur || (inc == cur && statusRank(other.Status[id]) > statusRank(s.Status[id])) {
+			wasDown := s.Status[id] == Suspect || s.Status[id] == Dead
+			s.Incarnation[id] = inc
+			s.Status[id] = other.Status[id]
+			if other.Status[id] != Suspect {
+				delete(s.suspectSince, id)
+			} else if _, ok := s.suspectSince[id]; !ok {
+				s.suspectSince[id] = time.Now()
+			}
+			if wasDown && other.Status[id] == Alive {
+				revived = append(revived, id)
+			}
+		}
+	}
+	return revived
+}
+
+func statusRank(st NodeStatus) int {
+	switch st {
+	case Dead:
+		return 2
+	case Suspect:
+		return 1
+	default:
+		return 0
 	}
+}
 
-	for k, v := range other.Nodes {
-		s.Nodes[k] = v
+// Snapshot returns a copy of the current nodeID -> addr membership map, safe
+// to read without holding a lock on the (possibly concurrently merging)
+// State itself.
+func (s *State) Snapshot() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nodes := make(map[string]string, len(s.Nodes))
+	for k, v := range s.Nodes {
+		nodes[k] = v
+	}
+	return nodes
+}
+
+// LiveNodes returns nodeID -> addr for every node not currently marked
+// Dead, so callers can skip dead peers immediately instead of eating a
+// full HTTP timeout trying to reach them.
+func (s *State) LiveNodes() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	live := make(map[string]string, len(s.Nodes))
+	for id, addr := range s.Nodes {
+		if s.Status[id] != Dead {
+			live[id] = addr
+		}
 	}
-	s.TS = other.TS
+	return live
 }
 
 // Start launches a goroutine that gossips every gossipInterval.
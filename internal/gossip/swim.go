@@ -0,0 +1,264 @@
+package gossip
+
+// SWIM-style failure detection. Each interval a node probes one random
+// peer directly; if that fails, it asks a few other peers to probe it on
+// its behalf (the probe target might just be unreachable from us
+// specifically) before giving up and marking it Suspect. A Suspect that
+// doesn't refute itself (by re-gossiping Alive at a higher incarnation)
+// within SuspectTimeout is marked Dead and dropped from the ring, so
+// handleKvPut/handleKvGet stop retrying it on every request instead of
+// eating a full HTTP timeout each time.
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"distributed-key-value-storage/internal/consistenthash"
+)
+
+const (
+	// ProbeTimeout bounds a single direct or indirect ping.
+	ProbeTimeout = 300 * time.Millisecond
+
+	// IndirectProbes is how many other peers are asked to ping a
+	// target on our behalf before we mark it Suspect.
+	IndirectProbes = 2
+
+	// SuspectTimeout is how long a node can stay Suspect without
+	// refuting before it's declared Dead.
+	SuspectTimeout = 5 * time.Second
+)
+
+// StartFailureDetector launches the SWIM probe loop.
+func StartFailureDetector(nodeID string, st *State, ring *consistenthash.Ring, interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			st.refuteIfSuspected(nodeID)
+			probeRandomPeer(nodeID, st, ring)
+			sweepSuspects(nodeID, st, ring)
+		}
+	}()
+}
+
+// refuteIfSuspected bumps our own incarnation and re-announces Alive if
+// gossip has told us someone suspects us - the whole point of carrying an
+// incarnation number instead of just a status flag.
+func (s *State) refuteIfSuspected(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Status[id] != Suspect {
+		return
+	}
+	s.Incarnation[id]++
+	s.Status[id] = Alive
+	delete(s.suspectSince, id)
+	s.TS = time.Now().UnixNano()
+}
+
+func probeRandomPeer(nodeID string, st *State, ring *consistenthash.Ring) {
+	targetID, targetAddr := st.randomPeer(nodeID)
+	if targetID == "" {
+		return
+	}
+
+	if ping(targetAddr) {
+		reviveIfNeeded(nodeID, targetID, st, ring)
+		return
+	}
+
+	if indirectPing(nodeID, st, targetID, targetAddr) {
+		reviveIfNeeded(nodeID, targetID, st, ring)
+		return
+	}
+
+	if st.markSuspect(targetID) {
+		log.Printf("[%s] suspecting %s (%s) after direct and indirect probes failed", nodeID, targetID, targetAddr)
+	}
+}
+
+// reviveIfNeeded marks targetID Alive and, if it had fallen out of the
+// ring via an earlier Suspect -> Dead transition, re-adds it - otherwise a
+// node that flaps (a transient partition, a GC pause, a restart) would be
+// evicted from the replica set for good the first time it's declared
+// Dead, even though gossip now shows it Alive again.
+func reviveIfNeeded(nodeID, targetID string, st *State, ring *consistenthash.Ring) {
+	if st.markAlive(targetID) {
+		ring.Add(targetID)
+		log.Printf("[%s] %s answered a probe again, re-added to the ring", nodeID, targetID)
+	}
+}
+
+// indirectPing asks up to IndirectProbes other peers to try reaching
+// targetAddr on our behalf, in case it's only unreachable from us.
+func indirectPing(nodeID string, st *State, targetID, targetAddr string) bool {
+	helpers := st.randomPeers(nodeID, targetID, IndirectProbes)
+	if len(helpers) == 0 {
+		return false
+	}
+
+	results := make(chan bool, len(helpers))
+	for _, addr := range helpers {
+		addr := addr
+		go func() { results <- pingReq(addr, targetAddr) }()
+	}
+
+	for range helpers {
+		if <-results {
+			return true
+		}
+	}
+	return false
+}
+
+func sweepSuspects(nodeID string, st *State, ring *consistenthash.Ring) {
+	for _, id := range st.expiredSuspects(SuspectTimeout) {
+		if id == nodeID {
+			continue
+		}
+		st.markDead(id)
+		ring.Remove(id)
+		log.Printf("[%s] marking %s dead after suspect timeout", nodeID, id)
+	}
+}
+
+// randomPeer returns a random known peer (excluding exclude and any
+// already-Dead node), or "" if none are known.
+func (s *State) randomPeer(exclude string) (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	candidates := make([]string, 0, len(s.Nodes))
+	for id := range s.Nodes {
+		if id == exclude || s.Status[id] == Dead {
+			continue
+		}
+		candidates = append(candidates, id)
+	}
+	if len(candidates) == 0 {
+		return "", ""
+	}
+
+	id := candidates[rand.Intn(len(candidates))]
+	return id, s.Nodes[id]
+}
+
+// randomPeers returns up to n random peer addresses, excluding the two
+// given node IDs and any already-Dead node.
+func (s *State) randomPeers(exclude1, exclude2 string, n int) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	candidates := make([]string, 0, len(s.Nodes))
+	for id, addr := range s.Nodes {
+		if id == exclude1 || id == exclude2 || s.Status[id] == Dead {
+			continue
+		}
+		candidates = append(candidates, addr)
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+// markAlive records id as Alive, returning true if it was previously
+// Suspect or Dead (i.e. this is a revival the caller needs to act on,
+// such as re-adding id to the hash ring).
+func (s *State) markAlive(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Status[id] == Alive {
+		return false
+	}
+	s.Status[id] = Alive
+	delete(s.suspectSince, id)
+	s.TS = time.Now().UnixNano()
+	return true
+}
+
+// markSuspect flags id as Suspect, returning true if this changed its
+// status (so the caller only logs on the actual transition).
+func (s *State) markSuspect(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Status[id] == Suspect || s.Status[id] == Dead {
+		return false
+	}
+	s.Status[id] = Suspect
+	s.suspectSince[id] = time.Now()
+	s.TS = time.Now().UnixNano()
+	return true
+}
+
+func (s *State) markDead(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Status[id] = Dead
+	delete(s.suspectSince, id)
+	s.TS = time.Now().UnixNano()
+}
+
+// expiredSuspects returns every node that's been Suspect for longer than
+// timeout without refuting.
+func (s *State) expiredSuspects(timeout time.Duration) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var expired []string
+	now := time.Now()
+	for id, since := range s.suspectSince {
+		if s.Status[id] == Suspect && now.Sub(since) > timeout {
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+// ping sends a direct SWIM probe to addr.
+func ping(addr string) bool {
+	cli := &http.Client{Timeout: ProbeTimeout}
+	resp, err := cli.Get("http://" + addr + "/gossip/ping")
+	if err != nil {
+		return false
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return resp.StatusCode == http.StatusOK
+}
+
+// pingReq asks helperAddr to probe targetAddr on our behalf.
+func pingReq(helperAddr, targetAddr string) bool {
+	cli := &http.Client{Timeout: ProbeTimeout}
+	resp, err := cli.Get(fmt.Sprintf("http://%s/gossip/ping-req?target=%s", helperAddr, targetAddr))
+	if err != nil {
+		return false
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return resp.StatusCode == http.StatusOK
+}
+
+// HandlePing responds to a direct SWIM probe: being reachable and
+// replying 200 is proof of liveness.
+func HandlePing(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandlePingReq probes ?target=addr on behalf of a peer that couldn't
+// reach it directly, and reports back whether it's alive.
+func HandlePingReq(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" || !ping(target) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,131 @@
+// Package hints implements hinted handoff: when a write can't reach one of
+// its replicas (the peer is down, or isn't even known to gossip yet), the
+// write is parked here instead of being silently dropped. A background
+// goroutine in node.Node periodically retries parked writes against their
+// intended target and deletes them once they land.
+package hints
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"distributed-key-value-storage/internal/store"
+)
+
+const (
+	// DefaultTTL bounds how long a hint is retried before it's given up on.
+	DefaultTTL = 24 * time.Hour
+
+	// DefaultMaxBytes bounds on-disk growth during a long outage.
+	DefaultMaxBytes int64 = 64 << 20 // 64 MiB
+)
+
+// Hint is a write that couldn't be delivered to TargetNodeID yet.
+type Hint struct {
+	TargetNodeID string      `json:"target_node_id"`
+	Entry        store.Entry `json:"entry"`
+	Expires      time.Time   `json:"expires"`
+}
+
+// Queue is an on-disk hint log: one file per parked write in dir, mirroring
+// how store writes one SSTable file per memtable flush. A hint file is
+// written once and removed once replayed (or expired) — never edited in
+// place.
+type Queue struct {
+	mu       sync.Mutex
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+}
+
+// NewQueue returns a Queue backed by dir, creating it if necessary.
+func NewQueue(dir string, ttl time.Duration, maxBytes int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Queue{dir: dir, ttl: ttl, maxBytes: maxBytes}, nil
+}
+
+// Add parks entry for later delivery to targetNodeID. If the queue is
+// already at its configured size limit the hint is dropped and logged,
+// rather than growing unbounded through a long outage.
+func (q *Queue) Add(targetNodeID string, entry store.Entry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.maxBytes > 0 && q.sizeLocked() >= q.maxBytes {
+		log.Printf("hints: queue at capacity, dropping hint for %s key=%s", targetNodeID, entry.Key)
+		return
+	}
+
+	h := Hint{TargetNodeID: targetNodeID, Entry: entry, Expires: time.Now().Add(q.ttl)}
+	path := filepath.Join(q.dir, fmt.Sprintf("%d-%s.hint", time.Now().UnixNano(), targetNodeID))
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("hints: create %s: %v", path, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := json.NewEncoder(f).Encode(h); err != nil {
+		log.Printf("hints: encode %s: %v", path, err)
+	}
+}
+
+func (q *Queue) sizeLocked() int64 {
+	files, _ := filepath.Glob(filepath.Join(q.dir, "*.hint"))
+	var total int64
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// Depth returns how many hints are currently parked, for observability.
+func (q *Queue) Depth() int {
+	files, _ := filepath.Glob(filepath.Join(q.dir, "*.hint"))
+	return len(files)
+}
+
+// Replay walks every parked hint, dropping anything past its TTL, and calls
+// send for the rest; a hint is deleted once send reports success.
+func (q *Queue) Replay(send func(targetNodeID string, e store.Entry) bool) {
+	files, _ := filepath.Glob(filepath.Join(q.dir, "*.hint"))
+	for _, path := range files {
+		h, ok := readHint(path)
+		if !ok {
+			continue
+		}
+
+		if time.Now().After(h.Expires) {
+			_ = os.Remove(path)
+			continue
+		}
+
+		if send(h.TargetNodeID, h.Entry) {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+func readHint(path string) (Hint, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Hint{}, false
+	}
+	defer func() { _ = f.Close() }()
+
+	var h Hint
+	if err := json.NewDecoder(f).Decode(&h); err != nil {
+		return Hint{}, false
+	}
+	return h, true
+}
@@ -6,12 +6,13 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"distributed-key-value-storage/internal/consistenthash"
 	"distributed-key-value-storage/internal/gossip"
+	"distributed-key-value-storage/internal/hints"
 	"distributed-key-value-storage/internal/quorum"
 	"distributed-key-value-storage/internal/store"
 )
@@ -24,24 +25,60 @@ const (
 
 // API request/response structures
 type (
-	kvReq struct {
-		Value []byte        `json:"value"`
-		Ver   store.Version `json:"version"`
+	// kvEntry is the wire format for a single sibling entry, used between
+	// replicas on /internal/kv (/internal/kv/digest sends bare versions).
+	kvEntry struct {
+		Value     []byte        `json:"value"`
+		Ver       store.Version `json:"version"`
+		Tombstone bool          `json:"tombstone,omitempty"`
 	}
 
-	kvResp struct {
-		Value []byte        `json:"value"`
-		Ver   store.Version `json:"version"`
+	// kvPutReq is the client-facing PUT body. Context is the opaque token
+	// from a prior GET, letting the client tell the coordinator which
+	// siblings it has already reconciled.
+	kvPutReq struct {
+		Value   []byte `json:"value"`
+		Context string `json:"context,omitempty"`
+	}
+
+	// kvDeleteReq is the client-facing DELETE body: the same context
+	// token a PUT would carry, so the tombstone causally dominates every
+	// sibling the client has already seen instead of merely racing them.
+	kvDeleteReq struct {
+		Context string `json:"context,omitempty"`
+	}
+
+	kvValue struct {
+		Value   []byte        `json:"value"`
+		Version store.Version `json:"version"`
+	}
+
+	// kvGetResp is the client-facing GET response: every current sibling,
+	// plus a context token to submit on the next PUT once they've been
+	// reconciled into one value.
+	kvGetResp struct {
+		Values  []kvValue `json:"values"`
+		Context string    `json:"context"`
+	}
+
+	merkleResp struct {
+		Root   uint64           `json:"root"`
+		Leaves []merkleLeafHash `json:"leaves,omitempty"`
+	}
+
+	merkleLeafHash struct {
+		Index int    `json:"index"`
+		Hash  uint64 `json:"hash"`
 	}
 )
 
 type Node struct {
-	ID      string
-	Addr    string
-	Store   *store.Store
-	Ring    *consistenthash.Ring
-	State   *gossip.State
-	Version uint64 // local Lamport clock
+	ID    string
+	Addr  string
+	Store *store.Store
+	Ring  *consistenthash.Ring
+	State *gossip.State
+	Hints *hints.Queue
 }
 
 func NewNode(
@@ -50,6 +87,7 @@ func NewNode(
 	store *store.Store,
 	ring *consistenthash.Ring,
 	state *gossip.State,
+	hintQueue *hints.Queue,
 ) *Node {
 	return &Node{
 		ID:    id,
@@ -57,9 +95,39 @@ func NewNode(
 		Store: store,
 		Ring:  ring,
 		State: state,
+		Hints: hintQueue,
 	}
 }
 
+// StartHintReplay launches a goroutine that periodically retries every
+// parked hint against its intended target, so a write that missed a
+// replica during an outage eventually lands once that replica is
+// reachable again instead of waiting for a client to re-read the key.
+func (n *Node) StartHintReplay(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			live := n.State.LiveNodes()
+			n.Hints.Replay(func(targetNodeID string, e store.Entry) bool {
+				dest := live[targetNodeID]
+				if dest == "" {
+					return false
+				}
+				return n.sendInternalPut(dest, e)
+			})
+		}
+	}()
+}
+
+// HandleHints reports the current hinted-handoff queue depth, for
+// observability into how much write traffic is parked waiting on an
+// unreachable replica.
+func (n *Node) HandleHints(w http.ResponseWriter, _ *http.Request) {
+	n.writeJSON(w, struct {
+		Depth int `json:"depth"`
+	}{Depth: n.Hints.Depth()})
+}
+
 // HandleKV handles external client requests to store (PUT) or retrieve (GET) key-value pairs.
 // It ensures replication across multiple nodes and maintains eventual
 // consistency using versioning.
@@ -69,26 +137,47 @@ func (n *Node) HandleKV(w http.ResponseWriter, r *http.Request) {
 		n.handleKvPut(w, r)
 	case http.MethodGet:
 		n.handleKvGet(w, r)
+	case http.MethodDelete:
+		n.handleKvDelete(w, r)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-// HandleInternalKV Internal replication endpoints
+// HandleInternalKV serves internal replication: GET returns every sibling
+// entry this node currently holds for a key, any other method (sendInternalPut
+// uses POST) stores one sibling sent by a coordinator or by
+// hinted-handoff/read-repair replay.
 func (n *Node) HandleInternalKV(w http.ResponseWriter, r *http.Request) {
-	var req kvReq
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
 	key := r.URL.Query().Get("key")
 	if key == "" {
 		http.Error(w, "key missing", http.StatusBadRequest)
 		return
 	}
-	stored := n.Store.Put(store.Entry{Key: key, Value: req.Value, Version: req.Ver})
+
+	if r.Method == http.MethodGet {
+		siblings, ok := n.Store.Get(key)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		out := make([]kvEntry, 0, len(siblings))
+		for _, e := range siblings {
+			out = append(out, kvEntry{Value: e.Value, Ver: e.Version, Tombstone: e.Tombstone})
+		}
+		n.writeJSON(w, out)
+		return
+	}
+
+	var req kvEntry
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored := n.Store.Put(store.Entry{Key: key, Value: req.Value, Version: req.Ver, Tombstone: req.Tombstone})
 	if stored {
 		w.WriteHeader(http.StatusCreated)
 	} else {
@@ -96,6 +185,30 @@ func (n *Node) HandleInternalKV(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandleInternalDigest serves just the versions of every sibling entry
+// for a key, without their values, so a quorum read can cheaply check
+// N-1 replicas for staleness instead of transferring the full values
+// from each of them.
+func (n *Node) HandleInternalDigest(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key missing", http.StatusBadRequest)
+		return
+	}
+
+	siblings, ok := n.Store.Get(key)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	versions := make([]store.Version, 0, len(siblings))
+	for _, e := range siblings {
+		versions = append(versions, e.Version)
+	}
+	n.writeJSON(w, versions)
+}
+
 func (n *Node) HandleGossip(_ http.ResponseWriter, r *http.Request) {
 	var st gossip.State
 	err := json.NewDecoder(r.Body).Decode(&st)
@@ -103,61 +216,167 @@ func (n *Node) HandleGossip(_ http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 	}
 
-	n.State.Merge(&st)
+	// A node that gossip shows coming back from Suspect/Dead needs to
+	// rejoin the ring, or sweepSuspects's earlier ring.Remove would evict
+	// it for good the first time it flaps.
+	for _, id := range n.State.Merge(&st) {
+		n.Ring.Add(id)
+	}
+}
+
+// HandleMerkle serves this node's Merkle-tree hashes for anti-entropy
+// repair (see internal/repair): the tree root plus every leaf's hash,
+// which is everything a peer needs to find which leaves diverge - there's
+// no intermediate level between the root and the flat leaf list to walk
+// down into a level at a time.
+func (n *Node) HandleMerkle(w http.ResponseWriter, _ *http.Request) {
+	leaves := make([]merkleLeafHash, 0, store.MerkleLeaves)
+	for i := 0; i < store.MerkleLeaves; i++ {
+		leaves = append(leaves, merkleLeafHash{Index: i, Hash: n.Store.MerkleLeafHash(i)})
+	}
+	n.writeJSON(w, merkleResp{Root: n.Store.MerkleRoot(), Leaves: leaves})
+}
+
+// HandleMerkleEntries returns the entries owned by a single leaf range, so
+// a peer that found the leaf's hash diverging can pull them and feed them
+// through its own Store.Put, letting version comparison pick the winner.
+func (n *Node) HandleMerkleEntries(w http.ResponseWriter, r *http.Request) {
+	idx, err := strconv.Atoi(r.URL.Query().Get("range"))
+	if err != nil || idx < 0 || idx >= store.MerkleLeaves {
+		http.Error(w, "invalid range", http.StatusBadRequest)
+		return
+	}
+	n.writeJSON(w, n.Store.MerkleLeafEntries(idx))
+}
+
+func (n *Node) writeJSON(w http.ResponseWriter, v any) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
 }
 
 func (n *Node) handleKvPut(w http.ResponseWriter, r *http.Request) {
 	// Extract the key from the request URL, e.g., "/kv/user42" → "user42"
 	key := strings.TrimPrefix(r.URL.Path, "/kv/")
 
-	body, err := io.ReadAll(r.Body)
+	var req kvPutReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, err := store.DecodeContext(req.Context)
 	if err != nil {
+		http.Error(w, "invalid context", http.StatusBadRequest)
+		return
+	}
+
+	// The coordinator bumps only its own entry in the clock the client
+	// last saw, rather than a node-wide counter: that's what lets two
+	// coordinators accept concurrent writes to the same key without one
+	// silently clobbering the other.
+	newEntry := store.Entry{Key: key, Value: req.Value, Version: ctx.Increment(n.ID)}
+
+	if quorum.IsQuorum(n.replicateEntry(newEntry), replicaFactor, writeQuorum) {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		http.Error(w, "quorum failed", http.StatusServiceUnavailable)
+	}
+}
+
+// handleKvDelete writes a tombstone for the key instead of a value: it
+// goes through the exact same replication/hinted-handoff path as a PUT, so
+// a concurrent write racing the delete is kept as a sibling rather than
+// one silently clobbering the other, and handleKvGet hides the tombstone
+// from the client once it's visible.
+func (n *Node) handleKvDelete(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/kv/")
+
+	var req kvDeleteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Create a new version using the Lamport clock (monotonically increasing per node)
-	newVersion := store.Version{
-		Counter: atomic.AddUint64(&n.Version, 1),
-		NodeID:  n.ID,
+	ctx, err := store.DecodeContext(req.Context)
+	if err != nil {
+		http.Error(w, "invalid context", http.StatusBadRequest)
+		return
 	}
 
-	// Create a new entry
-	newEntry := store.Entry{Key: key, Value: body, Version: newVersion}
+	tombstone := store.Entry{Key: key, Tombstone: true, Version: ctx.Increment(n.ID)}
 
+	if quorum.IsQuorum(n.replicateEntry(tombstone), replicaFactor, writeQuorum) {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		http.Error(w, "quorum failed", http.StatusServiceUnavailable)
+	}
+}
+
+// replicateEntry stores entry on every node responsible for its key,
+// parking it in hinted handoff for any replica that's unknown or
+// unreachable, and returns how many replicas acknowledged the write.
+func (n *Node) replicateEntry(entry store.Entry) int {
 	// Get the list of nodes responsible for this key (replication set)
-	nodes := n.Ring.Get(key, replicaFactor)
+	nodes := n.Ring.Get(entry.Key, replicaFactor)
+
+	// Skip nodes the SWIM failure detector has already declared Dead
+	// instead of paying a full HTTP timeout to rediscover that.
+	live := n.State.LiveNodes()
 
 	acknowledgements := 0 // Track how many replicas acknowledged the writing
 
 	for _, nodeID := range nodes {
 		if nodeID == n.ID {
-			// Store locally if this node is part of the replication set
-			if n.Store.Put(newEntry) {
+			// Store locally if this node is part of the replication set.
+			// A tombstone goes through Store.Delete rather than Put
+			// directly, even though Put would merge it in exactly the
+			// same way, so the intent at the call site matches the
+			// intent on disk.
+			var applied bool
+			if entry.Tombstone {
+				applied = n.Store.Delete(entry.Key, entry.Version)
+			} else {
+				applied = n.Store.Put(entry)
+			}
+			if applied {
 				acknowledgements++
 			}
 			continue
 		}
 
 		// Get the address of the target node from gossip state
-		targetNode := n.State.Nodes[nodeID]
+		targetNode := live[nodeID]
 		if targetNode == "" {
-			// Node is unknown or offline
+			// Node is unknown, offline, or dead: park the write so it
+			// isn't lost to this replica until it rejoins.
+			n.Hints.Add(nodeID, entry)
 			continue
 		}
 
 		// Send internal replication request to peer node
-		if n.sendInternalPut(targetNode, newEntry) {
+		if n.sendInternalPut(targetNode, entry) {
 			acknowledgements++
+		} else {
+			// Peer is known but unreachable right now; hand off the write
+			// for the background replayer to retry later.
+			n.Hints.Add(nodeID, entry)
 		}
 	}
 
-	// Check if write quorum is met (i.e., enough successful writes)
-	if quorum.IsQuorum(acknowledgements, replicaFactor, writeQuorum) {
-		w.WriteHeader(http.StatusCreated)
-	} else {
-		http.Error(w, "quorum failed", http.StatusServiceUnavailable)
-	}
+	return acknowledgements
+}
+
+// digestReply is one replica's answer to a read: either the full sibling
+// set (the local node, or the one remote replica asked for a full read)
+// or just sibling version digests, enough to tell whether it's missing or
+// holding a stale sibling without paying to transfer every value.
+type digestReply struct {
+	nodeID   string
+	dest     string // remote address, empty for the local node
+	entries  []store.Entry
+	versions []store.Version
+	full     bool
 }
 
 func (n *Node) handleKvGet(w http.ResponseWriter, r *http.Request) {
@@ -167,54 +386,157 @@ func (n *Node) handleKvGet(w http.ResponseWriter, r *http.Request) {
 	// Get the nodes that should store this key
 	nodes := n.Ring.Get(key, replicaFactor)
 
-	var winner store.Entry // The latest (most recent) value by version
-	acknowledgements := 0  // Successful read acknowledgments
+	// Skip nodes the SWIM failure detector has already declared Dead
+	// instead of paying a full HTTP timeout to rediscover that.
+	live := n.State.LiveNodes()
+
+	replies := make([]digestReply, 0, len(nodes))
+	askedFull := false
 
 	for _, nodeID := range nodes {
-		dest := n.State.Nodes[nodeID]
-
-		// Try to read from local store if it's us or if we don't know the peer
-		if nodeID == n.ID || dest == "" {
-			if entry, ok := n.Store.Get(key); ok {
-				// Choose the most recent version based on version comparison
-				if acknowledgements == 0 || entry.Version.Compare(winner.Version) > 0 {
-					winner = entry
-				}
-				acknowledgements++
+		// Reading locally is free, so always fetch the full sibling set.
+		if nodeID == n.ID {
+			if siblings, ok := n.Store.Get(key); ok {
+				replies = append(replies, digestReply{nodeID: nodeID, entries: siblings, full: true})
 			}
 			continue
 		}
 
-		// Ask another node for the value
-		if entry, ok := n.sendInternalGet(dest, key); ok {
-			if acknowledgements == 0 || entry.Version.Compare(winner.Version) > 0 {
-				winner = entry
+		dest := live[nodeID]
+		if dest == "" {
+			// Node is unknown, offline, or dead: skip it instead of
+			// substituting our own local data under its name, which
+			// would fabricate a second vote from a replica we never
+			// actually contacted and could mask a genuinely
+			// under-replicated read as quorum-satisfied.
+			continue
+		}
+
+		// Send one full read to satisfy quorum with actual values, and
+		// digest-only reads to the rest: we only need their versions to
+		// know whether they're missing or holding a stale sibling.
+		if !askedFull {
+			if siblings, ok := n.sendInternalGet(dest, key); ok {
+				replies = append(replies, digestReply{nodeID: nodeID, dest: dest, entries: siblings, full: true})
+				askedFull = true
 			}
-			acknowledgements++
+			continue
+		}
+
+		if versions, ok := n.sendInternalDigest(dest, key); ok {
+			replies = append(replies, digestReply{nodeID: nodeID, dest: dest, versions: versions})
 		}
 	}
 
 	// Check if read quorum is met (enough successful reads)
-	if quorum.IsQuorum(acknowledgements, replicaFactor, readQuorum) {
-		// Return the most recent version of the value
-		err := json.NewEncoder(w).Encode(kvResp{Value: winner.Value, Ver: winner.Version})
-		if err != nil {
-			log.Println(err)
-		}
-	} else {
+	if !quorum.IsQuorum(len(replies), replicaFactor, readQuorum) {
 		http.Error(w, "quorum failed", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Merge every full reply's siblings into one frontier, pruning
+	// anything strictly dominated by another sibling.
+	var frontier []store.Entry
+	for _, rep := range replies {
+		if rep.full {
+			frontier = append(frontier, rep.entries...)
+		}
+	}
+	frontier = store.PruneDominated(frontier)
+
+	// A digest-only reply whose version isn't dominated by anything in the
+	// frontier is concurrent with it: it contributes a sibling we haven't
+	// fetched a value for yet, so go get it before responding.
+	for _, rep := range replies {
+		if rep.full {
+			continue
+		}
+		for _, v := range rep.versions {
+			if dominatedByAny(v, frontier) {
+				continue
+			}
+			if siblings, ok := n.sendInternalGet(rep.dest, key); ok {
+				frontier = store.PruneDominated(append(frontier, siblings...))
+			}
+			break
+		}
+	}
+
+	// Read repair: asynchronously push any sibling a replica is missing
+	// (or only holds a now-pruned, stale version of) back to it, instead
+	// of waiting for background anti-entropy to catch it up.
+	for _, rep := range replies {
+		if rep.dest == "" {
+			continue
+		}
+
+		reported := rep.entries
+		for _, v := range rep.versions {
+			reported = append(reported, store.Entry{Version: v})
+		}
+
+		for _, want := range frontier {
+			if hasVersion(reported, want.Version) {
+				continue
+			}
+			dest, entry := rep.dest, want
+			go n.sendInternalPut(dest, entry)
+		}
+	}
+
+	// The context still needs to fold in a tombstone's version so a
+	// client's next write causally dominates the delete, but the
+	// tombstone itself isn't a value: skip it when building the list the
+	// client actually sees, or a deleted key would come back as a
+	// phantom sibling with an empty value instead of just being absent.
+	ctx := store.Version{}
+	values := make([]kvValue, 0, len(frontier))
+	for _, e := range frontier {
+		ctx = ctx.Merge(e.Version)
+		if e.Tombstone {
+			continue
+		}
+		values = append(values, kvValue{Value: e.Value, Version: e.Version})
+	}
+
+	err := json.NewEncoder(w).Encode(kvGetResp{Values: values, Context: store.EncodeContext(ctx)})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// dominatedByAny reports whether v is causally dominated by (or identical
+// to) the version of any entry in entries.
+func dominatedByAny(v store.Version, entries []store.Entry) bool {
+	for _, e := range entries {
+		switch v.Compare(e.Version) {
+		case store.Before, store.Equal:
+			return true
+		}
+	}
+	return false
+}
+
+// hasVersion reports whether any entry carries exactly version v.
+func hasVersion(entries []store.Entry, v store.Version) bool {
+	for _, e := range entries {
+		if e.Version.Compare(v) == store.Equal {
+			return true
+		}
 	}
+	return false
 }
 
-// sendInternalPut sends a key-value entry to a peer node for internal replication.
-// It performs an HTTP POST request to the /internal/kv endpoint on the destination node,
-// including the key as a query parameter and the value with version in the JSON body.
-// Returns true if the remote node acknowledges the writing (201 Created or 200 OK),
-// indicating that the entry was successfully stored or already up to date.
+// sendInternalPut sends a single sibling entry to a peer node for internal
+// replication or repair. It performs an HTTP POST request to the
+// /internal/kv endpoint on the destination node, including the key as a
+// query parameter and the value with version in the JSON body. Returns
+// true if the remote node acknowledges the write (201 Created or 200 OK),
+// indicating that the entry was stored or was already superseded there.
 func (n *Node) sendInternalPut(dest string, e store.Entry) bool {
 	cli := &http.Client{Timeout: 1 * time.Second}
 	query := fmt.Sprintf("http://%s/internal/kv?key=%s", dest, e.Key)
-	body, err := json.Marshal(kvReq{Value: e.Value, Ver: e.Version})
+	body, err := json.Marshal(kvEntry{Value: e.Value, Ver: e.Version, Tombstone: e.Tombstone})
 	if err != nil {
 		log.Printf("[%s] failed to marshal kv request: %v", e.Key, err)
 		return false
@@ -236,25 +558,53 @@ func (n *Node) sendInternalPut(dest string, e store.Entry) bool {
 	return resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusOK
 }
 
-func (n *Node) sendInternalGet(dest, key string) (store.Entry, bool) {
+// sendInternalGet fetches every sibling entry a peer holds for key.
+func (n *Node) sendInternalGet(dest, key string) ([]store.Entry, bool) {
 	cli := &http.Client{Timeout: 1 * time.Second}
 	url := fmt.Sprintf("http://%s/internal/kv?key=%s", dest, key)
 	resp, err := cli.Get(url)
 	if err != nil {
-		return store.Entry{}, false
+		return nil, false
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return store.Entry{}, false
+		return nil, false
 	}
 
-	var req kvReq
-	err = json.NewDecoder(resp.Body).Decode(&req)
+	var raw []kvEntry
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		log.Println(err)
+		return nil, false
+	}
+
+	entries := make([]store.Entry, 0, len(raw))
+	for _, e := range raw {
+		entries = append(entries, store.Entry{Key: key, Value: e.Value, Version: e.Ver, Tombstone: e.Tombstone})
+	}
+	return entries, len(entries) > 0
+}
+
+// sendInternalDigest asks a peer for just the versions of every sibling it
+// holds for key, via the /internal/kv/digest endpoint.
+func (n *Node) sendInternalDigest(dest, key string) ([]store.Version, bool) {
+	cli := &http.Client{Timeout: 1 * time.Second}
+	url := fmt.Sprintf("http://%s/internal/kv/digest?key=%s", dest, key)
+	resp, err := cli.Get(url)
 	if err != nil {
+		return nil, false
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+
+	var versions []store.Version
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
 		log.Println(err)
-		return store.Entry{}, false
+		return nil, false
 	}
 
-	return store.Entry{Key: key, Value: req.Value, Version: req.Ver}, true
+	return versions, true
 }
@@ -0,0 +1,174 @@
+// Package repair implements Merkle-tree based anti-entropy between
+// replicas, inspired by Dynamo/Cassandra: periodically compare this node's
+// tree against a random peer's, and for any leaf whose hash differs, pull
+// over only the entries under that leaf and feed them through Store.Put so
+// version comparison naturally picks the winner. Because only mismatched
+// leaves are ever transferred, a sync pass costs O(differences), not
+// O(keyspace).
+package repair
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"distributed-key-value-storage/internal/gossip"
+	"distributed-key-value-storage/internal/store"
+)
+
+const (
+	// DefaultInterval is how often a node picks a peer and syncs with it.
+	DefaultInterval = 30 * time.Second
+
+	// defaultEntryDelay throttles entry streaming so a repair pass doesn't
+	// saturate the network when many leaves diverge at once (e.g. after a
+	// long partition).
+	defaultEntryDelay = 5 * time.Millisecond
+
+	requestTimeout = 2 * time.Second
+)
+
+// Syncer periodically reconciles this node's store against a random peer
+// using the peer's Merkle tree.
+type Syncer struct {
+	nodeID     string
+	store      *store.Store
+	state      *gossip.State
+	interval   time.Duration
+	entryDelay time.Duration
+	cli        *http.Client
+}
+
+// NewSyncer returns a Syncer that repairs st against a random peer from
+// state every interval.
+func NewSyncer(nodeID string, st *store.Store, state *gossip.State, interval time.Duration) *Syncer {
+	return &Syncer{
+		nodeID:     nodeID,
+		store:      st,
+		state:      state,
+		interval:   interval,
+		entryDelay: defaultEntryDelay,
+		cli:        &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Start launches the background repair loop.
+func (s *Syncer) Start() {
+	go s.run()
+}
+
+func (s *Syncer) run() {
+	for {
+		time.Sleep(s.interval)
+		s.syncOnce()
+	}
+}
+
+// syncOnce picks a peer and walks its tree top-down, pulling over only the
+// leaves whose hash diverges from ours.
+func (s *Syncer) syncOnce() {
+	peer := s.pickPeer()
+	if peer == "" {
+		return
+	}
+
+	remote, ok := s.fetchTree(peer)
+	if !ok {
+		return
+	}
+	if remote.Root == s.store.MerkleRoot() {
+		return // already in sync, nothing to walk down into
+	}
+
+	for _, leaf := range remote.Leaves {
+		if leaf.Hash == s.store.MerkleLeafHash(leaf.Index) {
+			continue
+		}
+
+		entries, ok := s.fetchEntries(peer, leaf.Index)
+		if !ok {
+			continue
+		}
+		for _, e := range entries {
+			s.store.Put(e)
+			time.Sleep(s.entryDelay)
+		}
+	}
+}
+
+// pickPeer returns a random known peer address, excluding ourselves.
+func (s *Syncer) pickPeer() string {
+	nodes := s.state.Snapshot()
+
+	candidates := make([]string, 0, len(nodes))
+	for id, addr := range nodes {
+		if id == s.nodeID || addr == "" {
+			continue
+		}
+		candidates = append(candidates, addr)
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	return candidates[rand.Intn(len(candidates))]
+}
+
+type merkleResp struct {
+	Root   uint64 `json:"root"`
+	Leaves []struct {
+		Index int    `json:"index"`
+		Hash  uint64 `json:"hash"`
+	} `json:"leaves,omitempty"`
+}
+
+// fetchTree requests a peer's root plus every leaf hash. There's no
+// intermediate level to walk down into between the root and the flat leaf
+// list, so this always fetches the whole tree in one request.
+func (s *Syncer) fetchTree(peer string) (merkleResp, bool) {
+	url := "http://" + peer + "/internal/merkle"
+
+	resp, err := s.cli.Get(url)
+	if err != nil {
+		log.Printf("repair: fetch tree from %s: %v", peer, err)
+		return merkleResp{}, false
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return merkleResp{}, false
+	}
+
+	var out merkleResp
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		log.Printf("repair: decode tree from %s: %v", peer, err)
+		return merkleResp{}, false
+	}
+	return out, true
+}
+
+// fetchEntries pulls the full entries owned by one leaf range from a peer.
+func (s *Syncer) fetchEntries(peer string, leaf int) ([]store.Entry, bool) {
+	url := fmt.Sprintf("http://%s/internal/merkle/entries?range=%d", peer, leaf)
+
+	resp, err := s.cli.Get(url)
+	if err != nil {
+		log.Printf("repair: fetch entries from %s: %v", peer, err)
+		return nil, false
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+
+	var entries []store.Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		log.Printf("repair: decode entries from %s: %v", peer, err)
+		return nil, false
+	}
+	return entries, true
+}
@@ -0,0 +1,203 @@
+package store
+
+// Background SSTable compaction. L0 files are flushed straight from the
+// memtable and can overlap in key range, so a Get miss has to check all of
+// them; once there are enough, the compactor merges them into a single
+// non-overlapping L1 file (and so on for L1 -> L2, ...), dropping entries
+// superseded by a newer Version and finally discarding tombstones once
+// they've suppressed everything older. This bounds both the number of
+// files Get has to consult and the disk space held by overwritten or
+// deleted keys.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxLevel0Files is how many same-level files accumulate before
+	// they're compacted up a level.
+	maxLevel0Files = 4
+
+	// compactionFanIn caps how many files a single compaction merges at
+	// once, so one tick's pause is bounded even if a level has backed up.
+	compactionFanIn = 4
+)
+
+var sstableNameRE = regexp.MustCompile(`^(\d+)-L(\d+)\.sst$`)
+
+// sstableLevel parses the level embedded in an SSTable's filename, or -1
+// if the name doesn't match the expected "<ts>-L<level>.sst" pattern (e.g.
+// a stray file left over from before levels existed).
+func sstableLevel(path string) int {
+	m := sstableNameRE.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return -1
+	}
+	level, err := strconv.Atoi(m[2])
+	if err != nil {
+		return -1
+	}
+	return level
+}
+
+func sstablePath(dir string, ts int64, level int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d-L%d.sst", ts, level))
+}
+
+// StartCompactor launches a goroutine that periodically compacts one
+// over-full level, so read amplification stays bounded instead of growing
+// with every flush.
+func (s *Store) StartCompactor(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := s.compactOnce(); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+}
+
+// compactOnce compacts the lowest level that holds maxLevel0Files or more
+// files, or does nothing if none do. It only performs one
+// compaction per call, keeping each tick's pause small and predictable.
+func (s *Store) compactOnce() error {
+	byLevel := s.filesByLevel()
+
+	levels := make([]int, 0, len(byLevel))
+	for level := range byLevel {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+
+	for _, level := range levels {
+		files := byLevel[level]
+		if len(files) < maxLevel0Files {
+			continue
+		}
+
+		batch := files
+		if len(batch) > compactionFanIn {
+			batch = batch[:compactionFanIn]
+		}
+		return s.compact(batch, level+1)
+	}
+
+	return nil
+}
+
+func (s *Store) filesByLevel() map[int][]string {
+	files, _ := filepath.Glob(filepath.Join(s.dir, "*.sst"))
+
+	byLevel := make(map[int][]string)
+	for _, f := range files {
+		level := sstableLevel(f)
+		if level < 0 {
+			continue
+		}
+		byLevel[level] = append(byLevel[level], f)
+	}
+	for _, fs := range byLevel {
+		sort.Strings(fs)
+	}
+	return byLevel
+}
+
+// compact merges the given same-level files into one new file at dstLevel,
+// keeping only the causal antichain of siblings per key (entries
+// superseded by a newer Version are dropped) and finally discarding any
+// key left with nothing but a tombstone, then removes the input files and
+// their index/Bloom-filter companions.
+func (s *Store) compact(files []string, dstLevel int) error {
+	siblingsByKey := make(map[string][]Entry)
+
+	for _, f := range files {
+		entries, err := readAllEntries(f)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			merged, _ := mergeSiblings(siblingsByKey[e.Key], e)
+			siblingsByKey[e.Key] = merged
+		}
+	}
+
+	keys := make([]string, 0, len(siblingsByKey))
+	for k := range siblingsByKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Files outside this batch (an older L1 file, say) can still hold writes
+	// that a tombstone in the batch suppresses.
+	inBatch := make(map[string]bool, len(files))
+	for _, f := range files {
+		inBatch[f] = true
+	}
+	allFiles, _ := filepath.Glob(filepath.Join(s.dir, "*.sst"))
+	heldElsewhere := func(key string) bool {
+		for _, f := range allFiles {
+			if inBatch[f] {
+				continue
+			}
+			if _, ok := s.readFromSSTable(f, key); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	out := make([]Entry, 0, len(keys))
+	for _, k := range keys {
+		siblings := siblingsByKey[k]
+
+		// A lone tombstone with no surviving concurrent sibling, and no
+		// other SSTable holding the key, has fully suppressed every older
+		// write for this key; it can finally be dropped instead of being
+		// carried forward by every compaction. The Merkle tree needs to
+		// hear about that too, or it keeps claiming this key still holds
+		// the now-discarded tombstone: what's left is the memtable's part.
+		if len(siblings) == 1 && siblings[0].Tombstone && !heldElsewhere(k) {
+			s.mu.RLock()
+			rest := s.memtable[k]
+			s.mu.RUnlock()
+			s.merkle.touch(k, siblingsHash(rest))
+			continue
+		}
+
+		out = append(out, siblings...)
+		// Compaction can merge siblings across files in ways Put never
+		// saw (e.g. two concurrent writes that landed in different L0
+		// flushes), so the leaf hash recorded at write time may no longer
+		// match what's actually on disk. Recompute it from everything Get
+		// sees for the key (memtable and every file, not just this batch)
+		// so the Merkle tree stays authoritative.
+		if current, ok := s.Get(k); ok {
+			s.merkle.touch(k, siblingsHash(current))
+		}
+	}
+
+	path := sstablePath(s.dir, time.Now().UnixNano(), dstLevel)
+	if err := writeSSTableFile(path, out); err != nil {
+		return err
+	}
+	if err := s.merkle.saveTo(s.dir); err != nil {
+		log.Println(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range files {
+		_ = os.Remove(f)
+		_ = os.Remove(indexPathFor(f))
+		_ = os.Remove(bloomPathFor(f))
+	}
+	return nil
+}
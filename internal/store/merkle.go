@@ -0,0 +1,217 @@
+package store
+
+// Merkle-tree based anti-entropy support.
+//
+// The keyspace is split into a fixed number of leaf ranges; each leaf
+// summarizes the (key, version) pairs whose key hashes into it with a
+// single xxhash value. Two replicas that agree on every leaf hash are
+// known to hold the same data without ever comparing a full key list, and
+// when they disagree the repair package only needs to pull the handful of
+// leaves that actually differ. See internal/repair for the peer side of
+// this.
+//
+// Leaves are updated incrementally: Put marks the owning leaf dirty and
+// the hash is recomputed lazily, the next time it's read, so a hot key
+// doesn't pay a full rehash on every write.
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// merkleLeaves is the number of ranges the keyspace is split into. At
+// ~1024 keys per leaf this comfortably covers the small/medium datasets
+// this store targets; it is not resized at runtime.
+const merkleLeaves = 1024
+
+const merkleSnapshotFile = "merkle.json"
+
+type merkleTree struct {
+	mu     sync.Mutex
+	leaves []map[string]uint64 // per-leaf: key -> hash(key, version)
+	hashes []uint64            // per-leaf cached hash
+	dirty  map[int]struct{}
+}
+
+func newMerkleTree() *merkleTree {
+	return &merkleTree{
+		leaves: make([]map[string]uint64, merkleLeaves),
+		hashes: make([]uint64, merkleLeaves),
+		dirty:  make(map[int]struct{}),
+	}
+}
+
+// leafFor returns which leaf a key belongs to.
+func leafFor(key string) int {
+	return int(xxhash.Sum64String(key) % merkleLeaves)
+}
+
+// siblingsHash hashes the (key, version) tuple of every current sibling of
+// a key, so the leaf changes if a sibling is added, dropped, or its clock
+// advances, without needing to hash the values themselves.
+func siblingsHash(siblings []Entry) uint64 {
+	if len(siblings) == 0 {
+		return 0
+	}
+
+	// Sort by encoded version so the hash doesn't depend on sibling order.
+	encoded := make([]string, len(siblings))
+	for i, e := range siblings {
+		encoded[i] = e.Key + "|" + EncodeContext(e.Version)
+	}
+	sort.Strings(encoded)
+
+	h := xxhash.New()
+	for _, s := range encoded {
+		_, _ = h.WriteString(s)
+	}
+	return h.Sum64()
+}
+
+// touch records that key now has the given content hash and marks its
+// leaf dirty so the leaf hash is recomputed on next read.
+func (t *merkleTree) touch(key string, hash uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	idx := leafFor(key)
+	if t.leaves[idx] == nil {
+		t.leaves[idx] = make(map[string]uint64)
+	}
+	t.leaves[idx][key] = hash
+	t.dirty[idx] = struct{}{}
+}
+
+func (t *merkleTree) recomputeLocked() {
+	for idx := range t.dirty {
+		t.hashes[idx] = hashLeaf(t.leaves[idx])
+	}
+	t.dirty = make(map[int]struct{})
+}
+
+func hashLeaf(entries map[string]uint64) uint64 {
+	if len(entries) == 0 {
+		return 0
+	}
+
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := xxhash.New()
+	var buf [8]byte
+	for _, k := range keys {
+		_, _ = h.WriteString(k)
+		v := entries[k]
+		for i := 0; i < 8; i++ {
+			buf[i] = byte(v >> (8 * i))
+		}
+		_, _ = h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// root returns the combined hash of all leaves, recomputing any dirty ones.
+func (t *merkleTree) root() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.recomputeLocked()
+	h := xxhash.New()
+	var buf [8]byte
+	for _, lh := range t.hashes {
+		for i := 0; i < 8; i++ {
+			buf[i] = byte(lh >> (8 * i))
+		}
+		_, _ = h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// leafHash returns the hash of a single leaf, recomputing if dirty.
+func (t *merkleTree) leafHash(idx int) uint64 {
+	if idx < 0 || idx >= merkleLeaves {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.recomputeLocked()
+	return t.hashes[idx]
+}
+
+// keysIn returns the keys currently owned by a leaf.
+func (t *merkleTree) keysIn(idx int) []string {
+	if idx < 0 || idx >= merkleLeaves {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]string, 0, len(t.leaves[idx]))
+	for k := range t.leaves[idx] {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// merkleSnapshot is the on-disk form of a tree, written alongside SSTable
+// flushes so a restart doesn't force a full rebuild from scratch.
+type merkleSnapshot struct {
+	Leaves []map[string]uint64 `json:"leaves"`
+}
+
+func (t *merkleTree) saveTo(dir string) error {
+	t.mu.Lock()
+	snap := merkleSnapshot{Leaves: t.leaves}
+	t.mu.Unlock()
+
+	path := filepath.Join(dir, merkleSnapshotFile)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(snap); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadMerkleTree reads a previously saved snapshot from dir, or returns a
+// fresh empty tree if none exists yet.
+func loadMerkleTree(dir string) *merkleTree {
+	t := newMerkleTree()
+
+	f, err := os.Open(filepath.Join(dir, merkleSnapshotFile))
+	if err != nil {
+		return t
+	}
+	defer func() { _ = f.Close() }()
+
+	var snap merkleSnapshot
+	if err := json.NewDecoder(f).Decode(&snap); err != nil {
+		return t
+	}
+	for idx, leaf := range snap.Leaves {
+		if idx >= merkleLeaves || leaf == nil {
+			continue
+		}
+		t.leaves[idx] = leaf
+		t.dirty[idx] = struct{}{}
+	}
+	return t
+}
@@ -0,0 +1,224 @@
+package store
+
+// Sparse indexing and Bloom filters for SSTables, so Get can skip files
+// that provably don't hold a key and binary-search into the ones that
+// might, instead of JSON-decoding every line of every file on disk. See
+// compaction.go for the background process that keeps the file count (and
+// therefore how many of these need to be consulted per miss) bounded.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// sparseIndexInterval is how many distinct keys apart each sparse index
+// entry is: on a hit, readFromSSTable binary-searches the index down to
+// the closest entry at or before the key and scans forward from there
+// instead of from the start of the file.
+const sparseIndexInterval = 16
+
+// bloomBitsPerKey and bloomHashes trade a small, fixed per-file memory
+// footprint against the false-positive rate: ~10 bits/key and 7 hashes is
+// the standard choice for roughly a 1% false-positive rate.
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+)
+
+type indexEntry struct {
+	Key    string `json:"key"`
+	Offset int64  `json:"offset"`
+}
+
+type sparseIndex struct {
+	entries []indexEntry
+}
+
+// find returns the offset to start scanning an SSTable from for key: the
+// offset of the closest indexed key at or before it, or 0 if key sorts
+// before every indexed entry.
+func (idx *sparseIndex) find(key string) int64 {
+	i := sort.Search(len(idx.entries), func(i int) bool { return idx.entries[i].Key > key })
+	if i == 0 {
+		return 0
+	}
+	return idx.entries[i-1].Offset
+}
+
+func writeSparseIndex(path string, entries []indexEntry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return json.NewEncoder(f).Encode(entries)
+}
+
+func loadSparseIndex(path string) (*sparseIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var entries []indexEntry
+	if err := json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return &sparseIndex{entries: entries}, nil
+}
+
+// bloomFilter is a fixed-size packed bit array with bloomHashes
+// independent hashes, derived by salting xxhash rather than carrying
+// bloomHashes distinct hash functions.
+type bloomFilter struct {
+	bits []uint64 // packed bit array, 64 bits per word
+	m    uint64   // number of bits
+}
+
+func newBloomFilter(numKeys int) *bloomFilter {
+	m := uint64(numKeys*bloomBitsPerKey) + 64
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m}
+}
+
+func (b *bloomFilter) add(key string) {
+	for i := 0; i < bloomHashes; i++ {
+		h := xxhash.Sum64String(fmt.Sprintf("%d:%s", i, key)) % b.m
+		b.bits[h/64] |= 1 << (h % 64)
+	}
+}
+
+// mayContain reports whether key could be in the file this filter was
+// built from. A false answer is certain; a true answer may be a false
+// positive, so the caller still has to check the file itself.
+func (b *bloomFilter) mayContain(key string) bool {
+	for i := 0; i < bloomHashes; i++ {
+		h := xxhash.Sum64String(fmt.Sprintf("%d:%s", i, key)) % b.m
+		if b.bits[h/64]&(1<<(h%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func writeBloomFilter(path string, bf *bloomFilter) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return json.NewEncoder(f).Encode(struct {
+		Bits []uint64 `json:"bits"`
+		M    uint64   `json:"m"`
+	}{Bits: bf.bits, M: bf.m})
+}
+
+func loadBloomFilter(path string) (*bloomFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var raw struct {
+		Bits []uint64 `json:"bits"`
+		M    uint64   `json:"m"`
+	}
+	if err := json.NewDecoder(f).Decode(&raw); err != nil {
+		return nil, err
+	}
+	return &bloomFilter{bits: raw.Bits, m: raw.M}, nil
+}
+
+func indexPathFor(sstPath string) string {
+	return strings.TrimSuffix(sstPath, ".sst") + ".idx"
+}
+
+func bloomPathFor(sstPath string) string {
+	return strings.TrimSuffix(sstPath, ".sst") + ".bf"
+}
+
+// countingWriter tracks how many bytes have been written through it, so a
+// sparse index can record the exact offset of a line before it's encoded.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeSSTableFile writes entries (already sorted by Key) to path as
+// newline-delimited JSON, alongside a companion sparse index and Bloom
+// filter covering the same file.
+func writeSSTableFile(path string, entries []Entry) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	bw := bufio.NewWriter(file)
+	cw := &countingWriter{w: bw}
+	encoder := json.NewEncoder(cw)
+
+	bf := newBloomFilter(len(entries))
+	var idxEntries []indexEntry
+	lastKey := ""
+	distinctKeys := 0
+
+	for _, e := range entries {
+		bf.add(e.Key)
+		if e.Key != lastKey {
+			if distinctKeys%sparseIndexInterval == 0 {
+				idxEntries = append(idxEntries, indexEntry{Key: e.Key, Offset: cw.n})
+			}
+			distinctKeys++
+			lastKey = e.Key
+		}
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if err := writeSparseIndex(indexPathFor(path), idxEntries); err != nil {
+		return err
+	}
+	return writeBloomFilter(bloomPathFor(path), bf)
+}
+
+// readAllEntries decodes every line of an SSTable, for compaction's
+// benefit — unlike readFromSSTable it isn't looking for one key, so there's
+// nothing to skip.
+func readAllEntries(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var entries []Entry
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		var e Entry
+		if err := decoder.Decode(&e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -3,14 +3,14 @@ package store
 // Very small‑footprint key‑value storage engine with
 //  * in‑memory memtable
 //  * immutable on‑disk SSTables
-//  * basic versioning for conflict resolution
+//  * vector-clock versioning, keeping concurrent writes as siblings
+//    instead of picking a winner and discarding the rest
 //
 // This illustrates the concept used by Dynamo/Cassandra/HBase.
 
 import (
-	"bufio"
 	"encoding/json"
-	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -26,40 +26,69 @@ const (
 type (
 	Store struct {
 		mu             sync.RWMutex
-		memtable       map[string]Entry
+		memtable       map[string][]Entry // key -> concurrent sibling entries
 		dir            string
 		flushThreshold int
+		merkle         *merkleTree
 	}
 
 	Entry struct {
 		Key     string  `json:"key"`
 		Value   []byte  `json:"value"`
 		Version Version `json:"version"`
+		// Tombstone marks this sibling as a delete rather than a value. It
+		// still takes part in the same version-dominance rules as a
+		// regular write, so a concurrent put racing a delete is kept as a
+		// sibling instead of being silently lost; compaction drops a key
+		// entirely once it's left with nothing but a tombstone.
+		Tombstone bool `json:"tombstone,omitempty"`
 	}
 )
 
-// NewStore returns a Store that writes SSTables into dir.
+// NewStore returns a Store that writes SSTables into dir. If dir already
+// holds a Merkle-tree snapshot from a previous run, it is loaded so
+// anti-entropy repair (see internal/repair) doesn't have to rebuild the
+// tree from scratch after a restart.
 func NewStore(ssTablesDir string) *Store {
 	return &Store{
-		memtable:       make(map[string]Entry),
+		memtable:       make(map[string][]Entry),
 		dir:            ssTablesDir,
 		flushThreshold: flushThreshold,
+		merkle:         loadMerkleTree(ssTablesDir),
 	}
 }
 
-// Put updates a key if the version beats the existing one.
-// Returns true if stored.
+// Put merges entry into the sibling set for its key: any existing sibling
+// it causally dominates is dropped, and it is itself dropped if an
+// existing sibling already dominates (or equals) it. Otherwise it's kept
+// alongside the siblings it's concurrent with. Returns true if entry ended
+// up in the stored set (i.e. wasn't already superseded).
 func (s *Store) Put(entry Entry) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if e, ok := s.memtable[entry.Key]; ok {
-		if entry.Version.Compare(e.Version) <= 0 {
-			return false
+	existing := s.memtable[entry.Key]
+	if existing == nil {
+		// The key isn't in the memtable, which only happens if it was
+		// never written since the last flush or got flushed out to an
+		// SSTable. Either way its siblings (if any) now live on disk only,
+		// so pull them in before merging or a sibling written before a
+		// flush would silently vanish the moment a causally-concurrent one
+		// arrives afterward. Once pulled in, later Puts for the same key
+		// see it in the memtable and skip the rescan.
+		for _, e := range s.sstableSiblings(entry.Key) {
+			existing, _ = mergeSiblings(existing, e)
 		}
 	}
 
-	s.memtable[entry.Key] = entry
+	merged, added := mergeSiblings(existing, entry)
+	s.memtable[entry.Key] = merged
+	if !added {
+		return false
+	}
+
+	s.merkle.touch(entry.Key, siblingsHash(merged))
+
 	if len(s.memtable) >= s.flushThreshold {
 		err := s.writeToSSTable()
 		if err != nil {
@@ -70,36 +99,119 @@ func (s *Store) Put(entry Entry) bool {
 	return true
 }
 
-// Get retrieves from memtable then SSTables (newest‑first).
-func (s *Store) Get(key string) (Entry, bool) {
-	s.mu.RLock()
+// Delete writes a tombstone for key at the given version. The key is
+// treated as absent from the next Get onward, but the tombstone still
+// takes part in the same causal-dominance merge as Put, so a concurrent
+// write racing the delete is kept as a sibling instead of being silently
+// dropped by whichever one happens to land second.
+func (s *Store) Delete(key string, version Version) bool {
+	return s.Put(Entry{Key: key, Tombstone: true, Version: version})
+}
+
+// mergeSiblings folds e into an existing sibling set, keeping the result a
+// causal antichain: any sibling e dominates (or equals) is dropped, and e
+// itself is dropped if an existing sibling already dominates it. added
+// reports whether e ended up in the returned set.
+func mergeSiblings(existing []Entry, e Entry) (merged []Entry, added bool) {
+	merged = make([]Entry, 0, len(existing)+1)
+	superseded := false
 
-	if e, ok := s.memtable[key]; ok {
-		s.mu.RUnlock()
-		return e, true
+	for _, cur := range existing {
+		switch e.Version.Compare(cur.Version) {
+		case Before, Equal:
+			// cur already dominates (or is identical to) e: e has nothing
+			// new to contribute.
+			superseded = true
+			merged = append(merged, cur)
+		case After:
+			// e supersedes cur: drop cur from the sibling set.
+		case Concurrent:
+			merged = append(merged, cur)
+		}
+	}
+
+	if superseded {
+		return merged, false
 	}
+	return append(merged, e), true
+}
+
+// MerkleRoot returns the root hash of this store's Merkle tree, summarizing
+// every key it holds. Two replicas with the same root are in sync.
+func (s *Store) MerkleRoot() uint64 {
+	return s.merkle.root()
+}
+
+// MerkleLeafHash returns the hash of a single leaf range (0..MerkleLeaves).
+func (s *Store) MerkleLeafHash(leaf int) uint64 {
+	return s.merkle.leafHash(leaf)
+}
+
+// MerkleLeafEntries returns every sibling entry owned by a leaf range, for
+// a peer to pull during repair once it has found that the leaf's hash
+// differs.
+func (s *Store) MerkleLeafEntries(leaf int) []Entry {
+	keys := s.merkle.keysIn(leaf)
+	entries := make([]Entry, 0, len(keys))
+	for _, k := range keys {
+		if siblings, ok := s.Get(k); ok {
+			entries = append(entries, siblings...)
+		}
+	}
+	return entries
+}
+
+// MerkleLeaves is the number of leaf ranges the keyspace is split into.
+const MerkleLeaves = merkleLeaves
+
+// Get retrieves every sibling entry for a key, merging whatever the
+// memtable holds with whatever is spread across SSTables: a sibling can be
+// flushed while a causally-concurrent one is still in memory, so neither
+// source alone is authoritative and both have to be folded together.
+func (s *Store) Get(key string) ([]Entry, bool) {
+	s.mu.RLock()
+	merged := append([]Entry(nil), s.memtable[key]...)
 	s.mu.RUnlock()
 
-	// Get a list of SSTable files (*.sst) from the storage directory
-	files, _ := filepath.Glob(filepath.Join(s.dir, "*.sst"))
+	for _, e := range s.sstableSiblings(key) {
+		merged, _ = mergeSiblings(merged, e)
+	}
 
-	// Sort the file names in reverse order (newest files first)
-	// so we check the latest flushed SSTables before older ones
-	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	if len(merged) == 0 {
+		return nil, false
+	}
+	return merged, true
+}
+
+// sstableSiblings scans every SSTable file for key's siblings, merging
+// across files so a value split across an L0 file and a compacted L1 file
+// (say) still comes back as one antichain. It does not consult the
+// memtable.
+func (s *Store) sstableSiblings(key string) []Entry {
+	files, _ := filepath.Glob(filepath.Join(s.dir, "*.sst"))
 
-	// Iterate through SSTables one by one
+	var merged []Entry
 	for _, file := range files {
-		// Try to find the key in this SSTable
-		if e, ok := s.readFromSSTable(file, key); ok {
-			return e, true // Return immediately if found
+		// Skip files whose Bloom filter proves they can't hold key,
+		// without even opening them. A missing/unreadable filter just
+		// means we fall through to checking the file directly.
+		if bf, err := loadBloomFilter(bloomPathFor(file)); err == nil && !bf.mayContain(key) {
+			continue
+		}
+
+		siblings, ok := s.readFromSSTable(file, key)
+		if !ok {
+			continue
+		}
+		for _, e := range siblings {
+			merged, _ = mergeSiblings(merged, e)
 		}
 	}
 
-	// If the key wasn't found in either memtable or any SSTable
-	return Entry{}, false
+	return merged
 }
 
-// writes the current memtable to a new SSTable file on disk, then clears the memtable.
+// writes the current memtable to a new L0 SSTable file on disk, then clears the memtable.
 func (s *Store) writeToSSTable() error {
 	// If memtable is empty, no need to flush.
 	if len(s.memtable) == 0 {
@@ -107,65 +219,71 @@ func (s *Store) writeToSSTable() error {
 	}
 
 	// Create a snapshot (copy) of all current entries to avoid mutating the original
-	// map while writing.
+	// map while writing. Each key may contribute more than one line, one per sibling.
 	snap := make([]Entry, 0, len(s.memtable))
-	for _, e := range s.memtable {
-		snap = append(snap, e)
+	for _, siblings := range s.memtable {
+		snap = append(snap, siblings...)
 	}
 
-	// Sort the snapshot entries by key so the SSTable is ordered (helpful for
-	// future optimizations).
+	// Sort the snapshot entries by key so the SSTable is ordered: this is
+	// what lets the sparse index and readFromSSTable's forward scan work.
 	sort.Slice(snap, func(i, j int) bool { return snap[i].Key < snap[j].Key })
 
 	// Generate a unique filename based on current timestamp (nanoseconds).
-	ts := time.Now().UnixNano()
-	path := filepath.Join(s.dir, fmt.Sprintf("%d.sst", ts))
-
-	// Create the SSTable file on disk.
-	file, err := os.Create(path)
-	if err != nil {
+	// New flushes always land in L0; StartCompactor merges them upward.
+	path := sstablePath(s.dir, time.Now().UnixNano(), 0)
+	if err := writeSSTableFile(path, snap); err != nil {
 		return err
 	}
-	defer func() { _ = file.Close() }()
-
-	// Create a buffered writer for efficient disk I/O.
-	w := bufio.NewWriter(file)
 
-	// Create a JSON encoder to serialize entries line-by-line.
-	encoder := json.NewEncoder(w)
-
-	// Write each entry in the snapshot as a separate JSON line.
-	for _, e := range snap {
-		_ = encoder.Encode(e) // intentionally ignoring error for simplicity
-	}
+	// Clear the memtable after a successful flush
+	s.memtable = make(map[string][]Entry)
 
-	// Flush buffered data to the file.
-	err = w.Flush()
-	if err != nil {
-		return err
+	// Persist the Merkle tree alongside the SSTable so a restart doesn't
+	// force repair to rebuild it from scratch.
+	if err := s.merkle.saveTo(s.dir); err != nil {
+		log.Println(err)
 	}
 
-	// Clear the memtable after a successful flush
-	s.memtable = make(map[string]Entry)
-
 	return nil
 }
 
-// readFromSSTable scans file line by line until key found.
-func (s *Store) readFromSSTable(path string, key string) (Entry, bool) {
+// readFromSSTable collects every sibling entry for key from file. If a
+// companion sparse index exists it seeks to the closest indexed offset at
+// or before key first; since the file is sorted by key, it can then stop
+// as soon as it scans past key instead of reading to the end.
+func (s *Store) readFromSSTable(path string, key string) ([]Entry, bool) {
 	file, err := os.Open(path)
 	if err != nil {
-		return Entry{}, false
+		return nil, false
 	}
 	defer func() { _ = file.Close() }()
 
+	if idx, err := loadSparseIndex(indexPathFor(path)); err == nil {
+		if _, err := file.Seek(idx.find(key), io.SeekStart); err != nil {
+			return nil, false
+		}
+	}
+
+	var siblings []Entry
 	decoder := json.NewDecoder(file)
 	var e Entry
 	for decoder.More() {
-		if err := decoder.Decode(&e); err == nil && e.Key == key {
-			return e, true
+		// Start from a zero Entry each line: Decode would otherwise merge
+		// into the previous line's Version map and keep a stale Tombstone.
+		e = Entry{}
+		if err := decoder.Decode(&e); err != nil {
+			break
+		}
+		switch {
+		case e.Key < key:
+			continue // the sparse checkpoint may land a little before key
+		case e.Key > key:
+			return siblings, len(siblings) > 0 // sorted file: nothing further can match
+		default:
+			siblings = append(siblings, e)
 		}
 	}
 
-	return Entry{}, false
+	return siblings, len(siblings) > 0
 }
@@ -0,0 +1,73 @@
+package store
+
+import "testing"
+
+func TestMergeSiblingsDropsDominatedEntry(t *testing.T) {
+	existing := []Entry{{Key: "k", Value: []byte("old"), Version: Version{"a": 1}}}
+	next := Entry{Key: "k", Value: []byte("new"), Version: Version{"a": 2}}
+
+	merged, added := mergeSiblings(existing, next)
+
+	if !added {
+		t.Fatal("expected the dominating entry to be added")
+	}
+	if len(merged) != 1 || merged[0].Version["a"] != 2 {
+		t.Fatalf("got %+v, want only the dominating entry", merged)
+	}
+}
+
+func TestMergeSiblingsRejectsDominatedWrite(t *testing.T) {
+	existing := []Entry{{Key: "k", Value: []byte("new"), Version: Version{"a": 2}}}
+	stale := Entry{Key: "k", Value: []byte("old"), Version: Version{"a": 1}}
+
+	merged, added := mergeSiblings(existing, stale)
+
+	if added {
+		t.Fatal("expected the dominated entry to be rejected")
+	}
+	if len(merged) != 1 || merged[0].Version["a"] != 2 {
+		t.Fatalf("got %+v, want the existing entry unchanged", merged)
+	}
+}
+
+func TestMergeSiblingsKeepsConcurrentWrites(t *testing.T) {
+	existing := []Entry{{Key: "k", Value: []byte("from-a"), Version: Version{"a": 1}}}
+	concurrent := Entry{Key: "k", Value: []byte("from-b"), Version: Version{"b": 1}}
+
+	merged, added := mergeSiblings(existing, concurrent)
+
+	if !added {
+		t.Fatal("expected the concurrent entry to be kept as a sibling")
+	}
+	if len(merged) != 2 {
+		t.Fatalf("got %d siblings, want 2 (both kept as an antichain)", len(merged))
+	}
+}
+
+func TestMergeSiblingsTombstoneRacesConcurrentWrite(t *testing.T) {
+	existing := []Entry{{Key: "k", Value: []byte("from-a"), Version: Version{"a": 1}}}
+	tombstone := Entry{Key: "k", Tombstone: true, Version: Version{"b": 1}}
+
+	merged, added := mergeSiblings(existing, tombstone)
+
+	if !added {
+		t.Fatal("expected the concurrent tombstone to be kept as a sibling")
+	}
+	if len(merged) != 2 {
+		t.Fatalf("got %d siblings, want 2 (write and tombstone both survive as an antichain)", len(merged))
+	}
+}
+
+func TestMergeSiblingsDuplicateWriteIsNotAdded(t *testing.T) {
+	existing := []Entry{{Key: "k", Value: []byte("v"), Version: Version{"a": 1}}}
+	same := Entry{Key: "k", Value: []byte("v"), Version: Version{"a": 1}}
+
+	merged, added := mergeSiblings(existing, same)
+
+	if added {
+		t.Fatal("expected an identical version to not be re-added")
+	}
+	if len(merged) != 1 {
+		t.Fatalf("got %d siblings, want 1", len(merged))
+	}
+}
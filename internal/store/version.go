@@ -1,22 +1,150 @@
 package store
 
-type Version struct {
-	Counter uint64 `json:"counter"`
-	NodeID  string `json:"node_id"`
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// Version is a vector clock: one counter per node that has ever written
+// this entry. Unlike a single Lamport counter, it lets Compare tell apart
+// "this write causally follows that one" from "these two writes happened
+// concurrently and neither should be silently discarded".
+type Version map[string]uint64
+
+// Relation describes how two versions relate to each other.
+type Relation int
+
+const (
+	Equal Relation = iota
+	Before
+	After
+	Concurrent
+)
+
+// Compare returns how v relates to other: Equal if they're the same
+// clock, Before/After if one causally dominates the other, or Concurrent
+// if neither dominates - meaning both writes must be kept as siblings.
+func (v Version) Compare(other Version) Relation {
+	vGreater, otherGreater := false, false
+
+	for id := range union(v, other) {
+		a, b := v[id], other[id]
+		switch {
+		case a > b:
+			vGreater = true
+		case a < b:
+			otherGreater = true
+		}
+	}
+
+	switch {
+	case !vGreater && !otherGreater:
+		return Equal
+	case vGreater && !otherGreater:
+		return After
+	case !vGreater && otherGreater:
+		return Before
+	default:
+		return Concurrent
+	}
+}
+
+func union(a, b Version) map[string]struct{} {
+	ids := make(map[string]struct{}, len(a)+len(b))
+	for id := range a {
+		ids[id] = struct{}{}
+	}
+	for id := range b {
+		ids[id] = struct{}{}
+	}
+	return ids
+}
+
+// Increment returns a copy of v with nodeID's own counter bumped by one.
+// The coordinator handling a write calls this on the clock the client last
+// saw, rather than a node-wide counter, so two coordinators can advance
+// the same key concurrently without one clobbering the other.
+func (v Version) Increment(nodeID string) Version {
+	out := make(Version, len(v)+1)
+	for id, c := range v {
+		out[id] = c
+	}
+	out[nodeID]++
+	return out
+}
+
+// Merge returns the elementwise-max of v and other: the smallest clock
+// that dominates both, i.e. what a client gets once it has reconciled a
+// set of siblings into one merged value.
+func (v Version) Merge(other Version) Version {
+	out := make(Version, len(v)+len(other))
+	for id := range union(v, other) {
+		if a, b := v[id], other[id]; a > b {
+			out[id] = a
+		} else {
+			out[id] = b
+		}
+	}
+	return out
+}
+
+// EncodeContext serializes a Version into an opaque token a client can
+// round-trip back on its next PUT after reconciling siblings.
+func EncodeContext(v Version) string {
+	if len(v) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(b)
 }
 
-func (v Version) Compare(other Version) int {
-	if v.Counter > other.Counter {
-		return 1
-	} else if v.Counter < other.Counter {
-		return -1
+// DecodeContext parses a token produced by EncodeContext. An empty token
+// decodes to an empty (zero) clock, for a client's first write to a key.
+func DecodeContext(token string) (Version, error) {
+	if token == "" {
+		return Version{}, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
 	}
 
-	if v.NodeID > other.NodeID {
-		return 1
-	} else if v.NodeID < other.NodeID {
-		return -1
+	var v Version
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// PruneDominated drops any entry whose version is causally dominated by
+// (Before) or identical (Equal) to another entry in the slice, leaving
+// only the concurrent frontier of true siblings.
+func PruneDominated(entries []Entry) []Entry {
+	kept := make([]Entry, 0, len(entries))
+
+outer:
+	for i, e := range entries {
+		for j, other := range entries {
+			if i == j {
+				continue
+			}
+			switch e.Version.Compare(other.Version) {
+			case Before:
+				continue outer
+			case Equal:
+				// Keep exactly one of a pair of identical versions: the
+				// one with the lower index.
+				if j < i {
+					continue outer
+				}
+			}
+		}
+		kept = append(kept, e)
 	}
 
-	return 0
+	return kept
 }
@@ -0,0 +1,72 @@
+package store
+
+import "testing"
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		v    Version
+		w    Version
+		want Relation
+	}{
+		{"both empty", Version{}, Version{}, Equal},
+		{"identical", Version{"a": 1, "b": 2}, Version{"a": 1, "b": 2}, Equal},
+		{"v dominates on shared key", Version{"a": 2}, Version{"a": 1}, After},
+		{"w dominates on shared key", Version{"a": 1}, Version{"a": 2}, Before},
+		{"v has an extra counter", Version{"a": 1, "b": 1}, Version{"a": 1}, After},
+		{"w has an extra counter", Version{"a": 1}, Version{"a": 1, "b": 1}, Before},
+		{"concurrent: each ahead on a different node", Version{"a": 2, "b": 1}, Version{"a": 1, "b": 2}, Concurrent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.v.Compare(tt.w); got != tt.want {
+				t.Errorf("Compare(%v, %v) = %v, want %v", tt.v, tt.w, got, tt.want)
+			}
+
+			// Compare must be antisymmetric: swapping the operands swaps
+			// Before/After and leaves Equal/Concurrent unchanged.
+			want := tt.want
+			switch want {
+			case Before:
+				want = After
+			case After:
+				want = Before
+			}
+			if got := tt.w.Compare(tt.v); got != want {
+				t.Errorf("Compare(%v, %v) = %v, want %v", tt.w, tt.v, got, want)
+			}
+		})
+	}
+}
+
+func TestVersionIncrementDominatesOriginal(t *testing.T) {
+	base := Version{"a": 1}
+	bumped := base.Increment("b")
+
+	if got := base.Compare(bumped); got != Before {
+		t.Fatalf("base.Compare(bumped) = %v, want Before", got)
+	}
+	if bumped["a"] != 1 || bumped["b"] != 1 {
+		t.Fatalf("Increment produced %v, want a:1 b:1", bumped)
+	}
+	if base["b"] != 0 {
+		t.Fatalf("Increment mutated the receiver: %v", base)
+	}
+}
+
+func TestVersionMergeDominatesBothInputs(t *testing.T) {
+	v := Version{"a": 2, "b": 1}
+	w := Version{"a": 1, "b": 3}
+	merged := v.Merge(w)
+
+	if got := v.Compare(merged); got != Before && got != Equal {
+		t.Fatalf("v.Compare(merged) = %v, want Before or Equal", got)
+	}
+	if got := w.Compare(merged); got != Before && got != Equal {
+		t.Fatalf("w.Compare(merged) = %v, want Before or Equal", got)
+	}
+	if merged["a"] != 2 || merged["b"] != 3 {
+		t.Fatalf("Merge produced %v, want a:2 b:3", merged)
+	}
+}